@@ -0,0 +1,106 @@
+package mbserver
+
+// DataStore is the pluggable backend behind a Server's Modbus memory.
+// Every method is keyed by slave ID plus a register/coil address and
+// quantity, matching how the function-code handlers already address
+// memory, so a DataStore can be swapped in without touching them.
+// Implementations backed by a file, SQLite, Redis, or live process
+// variables can all satisfy this interface in place of the default
+// in-memory maps.
+type DataStore interface {
+	ReadCoils(slaveID uint8, address, quantity uint16) ([]byte, *Exception)
+	WriteCoils(slaveID uint8, address uint16, values []byte) *Exception
+	ReadDiscreteInputs(slaveID uint8, address, quantity uint16) ([]byte, *Exception)
+	ReadHoldingRegisters(slaveID uint8, address, quantity uint16) ([]uint16, *Exception)
+	WriteHoldingRegisters(slaveID uint8, address uint16, values []uint16) *Exception
+	ReadInputRegisters(slaveID uint8, address, quantity uint16) ([]uint16, *Exception)
+}
+
+// UseDataStore swaps in a pluggable DataStore backend, replacing the
+// default in-memory maps. The exported DiscreteInputs/Coils/
+// HoldingRegisters/InputRegisters maps keep working for direct access,
+// but function-code handlers that read or write through s.Store will use
+// the new backend from this point on.
+func (s *Server) UseDataStore(store DataStore) {
+	s.Store = store
+}
+
+// memoryDataStore is the default DataStore, backed directly by the
+// Server's own exported memory maps.
+type memoryDataStore struct {
+	server *Server
+}
+
+func (m *memoryDataStore) ReadCoils(slaveID uint8, address, quantity uint16) ([]byte, *Exception) {
+	coils, exists := m.server.Coils[slaveID]
+	if !exists {
+		return nil, &SlaveDeviceFailure
+	}
+	end := int(address) + int(quantity)
+	if end > len(coils) {
+		return nil, &IllegalDataAddress
+	}
+	return coils[address:end], &Success
+}
+
+func (m *memoryDataStore) WriteCoils(slaveID uint8, address uint16, values []byte) *Exception {
+	coils, exists := m.server.Coils[slaveID]
+	if !exists {
+		return &SlaveDeviceFailure
+	}
+	end := int(address) + len(values)
+	if end > len(coils) {
+		return &IllegalDataAddress
+	}
+	copy(coils[address:end], values)
+	return &Success
+}
+
+func (m *memoryDataStore) ReadDiscreteInputs(slaveID uint8, address, quantity uint16) ([]byte, *Exception) {
+	inputs, exists := m.server.DiscreteInputs[slaveID]
+	if !exists {
+		return nil, &SlaveDeviceFailure
+	}
+	end := int(address) + int(quantity)
+	if end > len(inputs) {
+		return nil, &IllegalDataAddress
+	}
+	return inputs[address:end], &Success
+}
+
+func (m *memoryDataStore) ReadHoldingRegisters(slaveID uint8, address, quantity uint16) ([]uint16, *Exception) {
+	registers, exists := m.server.HoldingRegisters[slaveID]
+	if !exists {
+		return nil, &SlaveDeviceFailure
+	}
+	end := int(address) + int(quantity)
+	if end > len(registers) {
+		return nil, &IllegalDataAddress
+	}
+	return registers[address:end], &Success
+}
+
+func (m *memoryDataStore) WriteHoldingRegisters(slaveID uint8, address uint16, values []uint16) *Exception {
+	registers, exists := m.server.HoldingRegisters[slaveID]
+	if !exists {
+		return &SlaveDeviceFailure
+	}
+	end := int(address) + len(values)
+	if end > len(registers) {
+		return &IllegalDataAddress
+	}
+	copy(registers[address:end], values)
+	return &Success
+}
+
+func (m *memoryDataStore) ReadInputRegisters(slaveID uint8, address, quantity uint16) ([]uint16, *Exception) {
+	registers, exists := m.server.InputRegisters[slaveID]
+	if !exists {
+		return nil, &SlaveDeviceFailure
+	}
+	end := int(address) + int(quantity)
+	if end > len(registers) {
+		return nil, &IllegalDataAddress
+	}
+	return registers[address:end], &Success
+}
@@ -0,0 +1,191 @@
+package mbserver
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+// registerAddressAndNumber parses the starting address and quantity
+// shared by every read/write-multiple request PDU. ok is false if the
+// frame is too short to contain them.
+func registerAddressAndNumber(frame Framer) (address uint16, quantity uint16, ok bool) {
+	data := frame.GetData()
+	if len(data) < 4 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint16(data[0:2]), binary.BigEndian.Uint16(data[2:4]), true
+}
+
+// registerAddressAndValue parses the address and value of a
+// write-single-register/coil request PDU. ok is false if the frame is
+// too short to contain them.
+func registerAddressAndValue(frame Framer) (address uint16, value uint16, ok bool) {
+	data := frame.GetData()
+	if len(data) < 4 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint16(data[0:2]), binary.BigEndian.Uint16(data[2:4]), true
+}
+
+// packBits bit-packs a per-value byte array (one byte per coil/discrete
+// input, nonzero meaning set) into the Modbus wire format.
+func packBits(values []byte) []byte {
+	byteCount := len(values) / 8
+	if len(values)%8 != 0 {
+		byteCount++
+	}
+
+	data := make([]byte, 1+byteCount)
+	data[0] = byte(byteCount)
+	for i, value := range values {
+		if value != 0 {
+			data[1+i/8] |= byte(1 << uint(i%8))
+		}
+	}
+	return data
+}
+
+// ReadCoils function 1.
+func ReadCoils(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	register, numRegs, ok := registerAddressAndNumber(frame)
+	if !ok {
+		return []byte{}, &IllegalDataValue
+	}
+	values, exception := s.Store.ReadCoils(frame.GetSlaveID(), register, numRegs)
+	if exception != &Success {
+		return []byte{}, exception
+	}
+	return packBits(values), &Success
+}
+
+// ReadDiscreteInputs function 2.
+func ReadDiscreteInputs(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	register, numRegs, ok := registerAddressAndNumber(frame)
+	if !ok {
+		return []byte{}, &IllegalDataValue
+	}
+	values, exception := s.Store.ReadDiscreteInputs(frame.GetSlaveID(), register, numRegs)
+	if exception != &Success {
+		return []byte{}, exception
+	}
+	return packBits(values), &Success
+}
+
+// ReadHoldingRegisters function 3.
+func ReadHoldingRegisters(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	register, numRegs, ok := registerAddressAndNumber(frame)
+	if !ok {
+		return []byte{}, &IllegalDataValue
+	}
+	values, exception := s.Store.ReadHoldingRegisters(frame.GetSlaveID(), register, numRegs)
+	if exception != &Success {
+		return []byte{}, exception
+	}
+
+	data := make([]byte, 1+len(values)*2)
+	data[0] = byte(len(values) * 2)
+	for i, v := range values {
+		binary.BigEndian.PutUint16(data[1+i*2:], v)
+	}
+	return data, &Success
+}
+
+// ReadInputRegisters function 4.
+func ReadInputRegisters(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	register, numRegs, ok := registerAddressAndNumber(frame)
+	if !ok {
+		return []byte{}, &IllegalDataValue
+	}
+	values, exception := s.Store.ReadInputRegisters(frame.GetSlaveID(), register, numRegs)
+	if exception != &Success {
+		return []byte{}, exception
+	}
+
+	data := make([]byte, 1+len(values)*2)
+	data[0] = byte(len(values) * 2)
+	for i, v := range values {
+		binary.BigEndian.PutUint16(data[1+i*2:], v)
+	}
+	return data, &Success
+}
+
+// WriteSingleCoil function 5.
+func WriteSingleCoil(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	register, rawValue, ok := registerAddressAndValue(frame)
+	if !ok {
+		return []byte{}, &IllegalDataValue
+	}
+
+	value := byte(0)
+	if rawValue != 0 {
+		value = 1
+	}
+
+	if exception := s.Store.WriteCoils(frame.GetSlaveID(), register, []byte{value}); exception != &Success {
+		return []byte{}, exception
+	}
+	return data[0:4], &Success
+}
+
+// WriteHoldingRegister function 6.
+func WriteHoldingRegister(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	register, value, ok := registerAddressAndValue(frame)
+	if !ok {
+		return []byte{}, &IllegalDataValue
+	}
+	if exception := s.Store.WriteHoldingRegisters(frame.GetSlaveID(), register, []uint16{value}); exception != &Success {
+		return []byte{}, exception
+	}
+	return frame.GetData()[0:4], &Success
+}
+
+// WriteMultipleCoils function 15.
+func WriteMultipleCoils(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	register, numCoils, ok := registerAddressAndNumber(frame)
+	if !ok {
+		return []byte{}, &IllegalDataValue
+	}
+
+	byteCount := (int(numCoils) + 7) / 8
+	if len(data) < 5 || int(data[4]) != byteCount || len(data) < 5+byteCount {
+		return []byte{}, &IllegalDataValue
+	}
+
+	values := make([]byte, numCoils)
+	for i := range values {
+		if data[5+i/8]&(1<<uint(i%8)) != 0 {
+			values[i] = 1
+		}
+	}
+
+	if exception := s.Store.WriteCoils(frame.GetSlaveID(), register, values); exception != &Success {
+		return []byte{}, exception
+	}
+	return data[0:4], &Success
+}
+
+// WriteHoldingRegisters function 16.
+func WriteHoldingRegisters(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	register, numRegs, ok := registerAddressAndNumber(frame)
+	if !ok {
+		return []byte{}, &IllegalDataValue
+	}
+
+	byteCount := int(numRegs) * 2
+	if len(data) < 5 || int(data[4]) != byteCount || len(data) < 5+byteCount {
+		return []byte{}, &IllegalDataValue
+	}
+
+	values := make([]uint16, numRegs)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(data[5+i*2 : 5+i*2+2])
+	}
+
+	if exception := s.Store.WriteHoldingRegisters(frame.GetSlaveID(), register, values); exception != &Success {
+		return []byte{}, exception
+	}
+	return data[0:4], &Success
+}
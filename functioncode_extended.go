@@ -0,0 +1,199 @@
+package mbserver
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+// fileRecordReferenceType is the only reference type the Modbus spec
+// defines for function codes 20/21 (Read/Write File Record).
+const fileRecordReferenceType = 6
+
+// ReadWriteMultipleRegisters function 23 (0x17): atomically writes the
+// request's write-registers before reading and returning the
+// read-registers, both against the slave's HoldingRegisters, per the
+// spec's ordering requirement.
+func ReadWriteMultipleRegisters(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 9 {
+		return []byte{}, &IllegalDataValue
+	}
+
+	readStart := binary.BigEndian.Uint16(data[0:2])
+	readQuantity := binary.BigEndian.Uint16(data[2:4])
+	writeStart := binary.BigEndian.Uint16(data[4:6])
+	writeQuantity := binary.BigEndian.Uint16(data[6:8])
+	writeByteCount := data[8]
+
+	if int(writeByteCount) != int(writeQuantity)*2 || len(data) != 9+int(writeByteCount) {
+		return []byte{}, &IllegalDataValue
+	}
+
+	writeValues := make([]uint16, writeQuantity)
+	for i := range writeValues {
+		writeValues[i] = binary.BigEndian.Uint16(data[9+i*2 : 9+i*2+2])
+	}
+
+	slaveID := frame.GetSlaveID()
+	if exception := s.Store.WriteHoldingRegisters(slaveID, writeStart, writeValues); exception != &Success {
+		return []byte{}, exception
+	}
+
+	values, exception := s.Store.ReadHoldingRegisters(slaveID, readStart, readQuantity)
+	if exception != &Success {
+		return []byte{}, exception
+	}
+
+	response := make([]byte, 1+int(readQuantity)*2)
+	response[0] = byte(readQuantity) * 2
+	for i, v := range values {
+		binary.BigEndian.PutUint16(response[1+i*2:], v)
+	}
+
+	return response, &Success
+}
+
+// MaskWriteRegister function 22 (0x16): applies an AND mask then an OR
+// mask to a single holding register, leaving bits set in and_mask
+// untouched and bits clear in and_mask taken from or_mask.
+func MaskWriteRegister(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 6 {
+		return []byte{}, &IllegalDataValue
+	}
+
+	address := binary.BigEndian.Uint16(data[0:2])
+	andMask := binary.BigEndian.Uint16(data[2:4])
+	orMask := binary.BigEndian.Uint16(data[4:6])
+
+	slaveID := frame.GetSlaveID()
+	current, exception := s.Store.ReadHoldingRegisters(slaveID, address, 1)
+	if exception != &Success {
+		return []byte{}, exception
+	}
+
+	result := (current[0] & andMask) | (orMask &^ andMask)
+	if exception := s.Store.WriteHoldingRegisters(slaveID, address, []uint16{result}); exception != &Success {
+		return []byte{}, exception
+	}
+
+	return data[0:6], &Success
+}
+
+// ReadFIFOQueue function 24 (0x18): reads a FIFO queue whose current
+// length is stored in the register at address, with up to 31 queued
+// values immediately following it.
+func ReadFIFOQueue(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 2 {
+		return []byte{}, &IllegalDataValue
+	}
+
+	address := binary.BigEndian.Uint16(data[0:2])
+	slaveID := frame.GetSlaveID()
+
+	counter, exception := s.Store.ReadHoldingRegisters(slaveID, address, 1)
+	if exception != &Success {
+		return []byte{}, exception
+	}
+
+	count := counter[0]
+	if count > 31 {
+		return []byte{}, &IllegalDataValue
+	}
+
+	values, exception := s.Store.ReadHoldingRegisters(slaveID, address+1, count)
+	if exception != &Success {
+		return []byte{}, exception
+	}
+
+	response := make([]byte, 4+int(count)*2)
+	binary.BigEndian.PutUint16(response[0:2], uint16(2+int(count)*2))
+	binary.BigEndian.PutUint16(response[2:4], count)
+	for i, v := range values {
+		binary.BigEndian.PutUint16(response[4+i*2:], v)
+	}
+
+	return response, &Success
+}
+
+// ReadFileRecord function 20 (0x14): reads one or more records from the
+// slave's extended file storage, addressed by file number and record
+// number rather than the flat register space.
+func ReadFileRecord(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 1 || len(data) != 1+int(data[0]) {
+		return []byte{}, &IllegalDataValue
+	}
+
+	files := s.FileRecords[frame.GetSlaveID()]
+	response := []byte{0}
+
+	for offset := 1; offset < len(data); {
+		if offset+7 > len(data) || data[offset] != fileRecordReferenceType {
+			return []byte{}, &IllegalDataValue
+		}
+
+		fileNumber := binary.BigEndian.Uint16(data[offset+1 : offset+3])
+		recordNumber := binary.BigEndian.Uint16(data[offset+3 : offset+5])
+		recordLength := binary.BigEndian.Uint16(data[offset+5 : offset+7])
+		offset += 7
+
+		record, exists := files[fileNumber][recordNumber]
+		if !exists || uint16(len(record)) < recordLength {
+			return []byte{}, &IllegalDataAddress
+		}
+
+		subResponse := make([]byte, 2+int(recordLength)*2)
+		subResponse[0] = byte(1 + int(recordLength)*2)
+		subResponse[1] = fileRecordReferenceType
+		for i, v := range record[:recordLength] {
+			binary.BigEndian.PutUint16(subResponse[2+i*2:], v)
+		}
+
+		response[0] += byte(len(subResponse))
+		response = append(response, subResponse...)
+	}
+
+	return response, &Success
+}
+
+// WriteFileRecord function 21 (0x15): writes one or more records into the
+// slave's extended file storage and, per the spec, echoes the request
+// back unchanged as the response.
+func WriteFileRecord(ctx context.Context, s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 1 || len(data) != 1+int(data[0]) {
+		return []byte{}, &IllegalDataValue
+	}
+
+	files := s.FileRecords[frame.GetSlaveID()]
+
+	for offset := 1; offset < len(data); {
+		if offset+7 > len(data) || data[offset] != fileRecordReferenceType {
+			return []byte{}, &IllegalDataValue
+		}
+
+		fileNumber := binary.BigEndian.Uint16(data[offset+1 : offset+3])
+		recordNumber := binary.BigEndian.Uint16(data[offset+3 : offset+5])
+		recordLength := binary.BigEndian.Uint16(data[offset+5 : offset+7])
+		offset += 7
+
+		if offset+int(recordLength)*2 > len(data) {
+			return []byte{}, &IllegalDataValue
+		}
+
+		record := make([]uint16, recordLength)
+		for i := range record {
+			record[i] = binary.BigEndian.Uint16(data[offset+i*2 : offset+i*2+2])
+		}
+		offset += int(recordLength) * 2
+
+		if files[fileNumber] == nil {
+			files[fileNumber] = make(map[uint16][]uint16)
+		}
+		files[fileNumber][recordNumber] = record
+	}
+
+	return data, &Success
+}
@@ -0,0 +1,76 @@
+package mbserver
+
+import "context"
+
+type contextKey int
+
+const (
+	remoteAddrContextKey contextKey = iota
+	transactionIDContextKey
+	slaveIDContextKey
+	functionCodeContextKey
+)
+
+// RemoteAddrFromContext returns the client address a request arrived
+// from, or "" if ctx carries none (e.g. a serial connection).
+func RemoteAddrFromContext(ctx context.Context) string {
+	addr, _ := ctx.Value(remoteAddrContextKey).(string)
+	return addr
+}
+
+// TransactionIDFromContext returns the MBAP transaction ID a request
+// arrived with, or 0 if ctx carries none.
+func TransactionIDFromContext(ctx context.Context) uint16 {
+	id, _ := ctx.Value(transactionIDContextKey).(uint16)
+	return id
+}
+
+// SlaveIDFromContext returns the slave ID a request was addressed to.
+func SlaveIDFromContext(ctx context.Context) uint8 {
+	id, _ := ctx.Value(slaveIDContextKey).(uint8)
+	return id
+}
+
+// FunctionCodeFromContext returns the Modbus function code a request
+// invoked.
+func FunctionCodeFromContext(ctx context.Context) uint8 {
+	code, _ := ctx.Value(functionCodeContextKey).(uint8)
+	return code
+}
+
+// withRequestContext returns a context derived from parent carrying the
+// slave ID and function code of frame, for handlers, hooks, and the
+// Logger to read back via the FromContext helpers above.
+func withRequestContext(parent context.Context, frame Framer) context.Context {
+	ctx := context.WithValue(parent, slaveIDContextKey, frame.GetSlaveID())
+	return context.WithValue(ctx, functionCodeContextKey, frame.GetFunction())
+}
+
+// Logger receives structured events for every request the Server
+// handles. Implementations can forward them to any logging or metrics
+// backend. It is optional: while Server.Logger is nil, no events are
+// delivered.
+type Logger interface {
+	LogRequest(ctx context.Context, frame Framer)
+	LogResponse(ctx context.Context, frame Framer, exception *Exception)
+}
+
+// runRequestHooks invokes the OnRequest/Logger request hooks, if set.
+func (s *Server) runRequestHooks(ctx context.Context, frame Framer) {
+	if s.OnRequest != nil {
+		s.OnRequest(ctx, frame)
+	}
+	if s.Logger != nil {
+		s.Logger.LogRequest(ctx, frame)
+	}
+}
+
+// runResponseHooks invokes the OnResponse/Logger response hooks, if set.
+func (s *Server) runResponseHooks(ctx context.Context, frame Framer, exception *Exception) {
+	if s.OnResponse != nil {
+		s.OnResponse(ctx, frame, exception)
+	}
+	if s.Logger != nil {
+		s.Logger.LogResponse(ctx, frame, exception)
+	}
+}
@@ -2,14 +2,24 @@
 package mbserver
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 
 	"github.com/goburrow/serial"
 )
 
-type functionCodeTable [256](func(*Server, Framer) ([]byte, *Exception))
+type functionCodeTable [256](func(context.Context, *Server, Framer) ([]byte, *Exception))
+
+// slaveWorkers is the number of goroutines processing each slave's request
+// queue. Running several lets concurrent read requests for the same slave
+// proceed in parallel under its RWMutex, while a write request still has
+// to wait for exclusive access.
+const slaveWorkers = 4
 
 // Server is a Modbus slave with allocated memory for discrete inputs, coils, etc.
 type Server struct {
@@ -18,17 +28,43 @@ type Server struct {
 	listeners        []net.Listener
 	ports            []serial.Port
 	requestChan      chan *Request
+	slaveQueues      map[uint8]chan *Request
+	locks            map[uint8]*sync.RWMutex
 	function         map[uint8]functionCodeTable
 	DiscreteInputs   map[uint8][]byte
 	Coils            map[uint8][]byte
 	HoldingRegisters map[uint8][]uint16
 	InputRegisters   map[uint8][]uint16
+	// FileRecords backs function codes 20/21 (Read/Write File Record),
+	// keyed by slave ID, then file number, then record number.
+	FileRecords map[uint8]map[uint16]map[uint16][]uint16
+	// Store is the pluggable backend function-code handlers read and
+	// write memory through. It defaults to an in-memory store backed by
+	// the maps above; replace it with UseDataStore.
+	Store     DataStore
+	authorize AuthorizeFunc
+	// OnRequest, if set, is called with each request's context and frame
+	// before its handler runs.
+	OnRequest func(ctx context.Context, frame Framer)
+	// OnResponse, if set, is called with each request's context, frame,
+	// and resulting exception after its handler has run.
+	OnResponse func(ctx context.Context, frame Framer, exception *Exception)
+	// Logger, if set, additionally receives every request and response
+	// as structured events; see the Logger interface.
+	Logger Logger
 }
 
 // Request contains the connection and Modbus frame.
 type Request struct {
 	conn  io.ReadWriteCloser
 	frame Framer
+	// cert is the client certificate presented over a ListenTLS
+	// connection, or nil for plain TCP/serial requests.
+	cert *x509.Certificate
+	// ctx carries the request's remote address and transaction ID, and is
+	// canceled when the originating connection disconnects so long-running
+	// custom handlers can abort; handle adds the slave ID and function code.
+	ctx context.Context
 }
 
 // NewServer creates a new Modbus server (slave).
@@ -41,6 +77,10 @@ func NewServer(slaveIDs []uint8) *Server {
 	s.HoldingRegisters = make(map[uint8][]uint16)
 	s.InputRegisters = make(map[uint8][]uint16)
 	s.function = make(map[uint8]functionCodeTable)
+	s.locks = make(map[uint8]*sync.RWMutex)
+	s.slaveQueues = make(map[uint8]chan *Request)
+	s.FileRecords = make(map[uint8]map[uint16]map[uint16][]uint16)
+	s.Store = &memoryDataStore{server: s}
 
 	for _, ID := range slaveIDs {
 		// Allocate Modbus memory maps.
@@ -48,7 +88,10 @@ func NewServer(slaveIDs []uint8) *Server {
 		s.Coils[ID] = make([]byte, 65536)
 		s.HoldingRegisters[ID] = make([]uint16, 65536)
 		s.InputRegisters[ID] = make([]uint16, 65536)
+		s.FileRecords[ID] = make(map[uint16]map[uint16][]uint16)
 		s.function[ID] = functionCodeTable{}
+		s.locks[ID] = &sync.RWMutex{}
+		s.slaveQueues[ID] = make(chan *Request)
 
 		// Add default functions.
 		s.RegisterFunctionHandler(ID, 1, ReadCoils)
@@ -59,16 +102,28 @@ func NewServer(slaveIDs []uint8) *Server {
 		s.RegisterFunctionHandler(ID, 6, WriteHoldingRegister)
 		s.RegisterFunctionHandler(ID, 15, WriteMultipleCoils)
 		s.RegisterFunctionHandler(ID, 16, WriteHoldingRegisters)
+		s.RegisterFunctionHandler(ID, 20, ReadFileRecord)
+		s.RegisterFunctionHandler(ID, 21, WriteFileRecord)
+		s.RegisterFunctionHandler(ID, 22, MaskWriteRegister)
+		s.RegisterFunctionHandler(ID, 23, ReadWriteMultipleRegisters)
+		s.RegisterFunctionHandler(ID, 24, ReadFIFOQueue)
+
+		for i := 0; i < slaveWorkers; i++ {
+			go s.slaveWorker(ID)
+		}
 	}
 
 	s.requestChan = make(chan *Request)
-	go s.handler()
+	go s.dispatch()
 
 	return s
 }
 
 // RegisterFunctionHandler override the default behavior for a given Modbus function.
-func (s *Server) RegisterFunctionHandler(slaveID uint8, funcCode uint8, function func(*Server, Framer) ([]byte, *Exception)) error {
+// The context passed to function carries the request's remote address,
+// transaction ID, slave ID, and function code, and is canceled if the
+// originating connection disconnects while the handler is still running.
+func (s *Server) RegisterFunctionHandler(slaveID uint8, funcCode uint8, function func(context.Context, *Server, Framer) ([]byte, *Exception)) error {
 	table, exists := s.function[slaveID]
 	if !exists {
 		return fmt.Errorf("Unable to register function for undefined slave ID: %d", slaveID)
@@ -78,38 +133,95 @@ func (s *Server) RegisterFunctionHandler(slaveID uint8, funcCode uint8, function
 	return nil
 }
 
-func (s *Server) handle(request *Request) Framer {
+// isReadFunction reports whether funcCode only reads Modbus memory, and so
+// may run concurrently with other reads for the same slave under an
+// RLock. Every other function code — writes and anything not in the
+// default table — takes the slave's full Lock.
+func isReadFunction(funcCode uint8) bool {
+	switch funcCode {
+	case 1, 2, 3, 4, 20, 24:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) handle(slaveID uint8, request *Request) Framer {
 	var exception *Exception
 	var data []byte
 
+	ctx := request.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = withRequestContext(ctx, request.frame)
+
+	s.runRequestHooks(ctx, request.frame)
+
 	response := request.frame.Copy()
 
 	function := request.frame.GetFunction()
-	slaveID := request.frame.GetSlaveID()
 	functions, exists := s.function[slaveID]
 	if !exists {
 		exception = &SlaveDeviceFailure
+	} else if _, isTLS := request.conn.(*tls.Conn); isTLS && s.authorize != nil && !s.authorize(request.cert, slaveID, function) {
+		exception = &SlaveDeviceFailure
+	} else if functions[function] == nil {
+		exception = &IllegalFunction
 	} else {
-		if functions[function] != nil {
-			data, exception = s.function[slaveID][function](s, request.frame)
-			response.SetData(data)
+		lock := s.locks[slaveID]
+		if isReadFunction(function) {
+			lock.RLock()
+			data, exception = functions[function](ctx, s, request.frame)
+			lock.RUnlock()
 		} else {
-			exception = &IllegalFunction
+			lock.Lock()
+			data, exception = functions[function](ctx, s, request.frame)
+			lock.Unlock()
 		}
+		response.SetData(data)
 	}
 
 	if exception != &Success {
 		response.SetException(exception)
 	}
 
+	s.runResponseHooks(ctx, request.frame, exception)
+
 	return response
 }
 
-// All requests are handled synchronously to prevent modbus memory corruption.
-func (s *Server) handler() {
-	for {
-		request := <-s.requestChan
-		response := s.handle(request)
+// dispatch reads incoming requests off requestChan and routes each one in
+// its own goroutine, so that a request addressed to a slave whose worker
+// pool is currently saturated blocks only that goroutine's send to
+// slaveQueues — never the shared dispatch loop itself. Without this, one
+// slow slave would back up requestChan and stall every other slave
+// waiting behind it.
+func (s *Server) dispatch() {
+	for request := range s.requestChan {
+		go s.route(request)
+	}
+}
+
+// route sends request to the worker pool for its slave ID.
+func (s *Server) route(request *Request) {
+	slaveID := request.frame.GetSlaveID()
+	queue, exists := s.slaveQueues[slaveID]
+	if !exists {
+		response := request.frame.Copy()
+		response.SetException(&SlaveDeviceFailure)
+		request.conn.Write(response.Bytes())
+		return
+	}
+	queue <- request
+}
+
+// slaveWorker is one of a slave's worker pool. Concurrent read requests
+// for the slave are safe to interleave across workers because handle
+// takes the slave's RWMutex for RLock on reads and full Lock on writes.
+func (s *Server) slaveWorker(slaveID uint8) {
+	for request := range s.slaveQueues[slaveID] {
+		response := s.handle(slaveID, request)
 		request.conn.Write(response.Bytes())
 	}
 }
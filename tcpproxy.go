@@ -0,0 +1,161 @@
+package mbserver
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+// ProxyBackend is an upstream Modbus endpoint (a serial RTU bus or another
+// TCP slave) that a proxy listener forwards PDUs to. Implementations are
+// responsible for their own framing (RTU CRC, MBAP, ...) and must be safe
+// to call from a single goroutine at a time; ListenTCPProxy never calls
+// Transact concurrently.
+type ProxyBackend interface {
+	// Transact sends a Modbus PDU (function code followed by data, with no
+	// MBAP header or CRC) addressed to slaveID and returns the upstream's
+	// PDU response, or an error if the upstream could not be reached.
+	// ctx is canceled once the per-request timeout passed to
+	// ListenTCPProxy elapses; implementations should abandon the
+	// transaction and return ctx.Err() (or any other error) once that
+	// happens.
+	Transact(ctx context.Context, slaveID uint8, pdu []byte) ([]byte, error)
+}
+
+// proxyRequest couples an inbound MBAP frame with the channel its response
+// must be delivered on, so concurrent TCP clients can share the single
+// upstream connection without interleaving requests on the wire.
+type proxyRequest struct {
+	ctx      context.Context
+	slaveID  uint8
+	pdu      []byte
+	response chan proxyResult
+}
+
+type proxyResult struct {
+	pdu []byte
+	err error
+}
+
+// ListenTCPProxy starts a Modbus/TCP-to-RTU proxy on addr: incoming MBAP
+// frames are queued and forwarded one at a time to upstream, and the
+// upstream's response is relayed back to the originating client with the
+// original transaction ID restored. The local memory maps are never
+// consulted. timeout bounds how long a single request waits on the
+// upstream before the client gets a SlaveDeviceFailure response, so a
+// wedged backend can't hang a client connection forever.
+func (s *Server) ListenTCPProxy(addr string, upstream ProxyBackend, timeout time.Duration) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	requests := make(chan *proxyRequest)
+	s.listeners = append(s.listeners, listener)
+
+	go proxyWorker(upstream, requests)
+	go acceptProxyConnections(listener, requests, timeout)
+
+	return nil
+}
+
+// proxyWorker serializes concurrent client requests onto the single
+// upstream backend, handing them to Transact one at a time. The response channel is
+// buffered, so a worker that's still waiting on a request the client has
+// already timed out on can deliver its result without blocking.
+func proxyWorker(upstream ProxyBackend, requests chan *proxyRequest) {
+	for request := range requests {
+		pdu, err := upstream.Transact(request.ctx, request.slaveID, request.pdu)
+		request.response <- proxyResult{pdu: pdu, err: err}
+	}
+}
+
+func acceptProxyConnections(listener net.Listener, requests chan *proxyRequest, timeout time.Duration) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go handleProxyConnection(conn, requests, timeout)
+	}
+}
+
+// handleProxyConnection reads MBAP frames off conn, forwards each one's
+// PDU to the upstream via requests, and writes back the upstream's
+// response wrapped in a fresh MBAP header that echoes the client's
+// transaction ID and unit ID. Each request is bounded by timeout: if the
+// upstream hasn't answered by then, the client gets a SlaveDeviceFailure
+// response instead of blocking forever.
+func handleProxyConnection(conn io.ReadWriteCloser, requests chan *proxyRequest, timeout time.Duration) {
+	defer conn.Close()
+
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+
+		transactionID := header[0:2]
+		length := binary.BigEndian.Uint16(header[4:6])
+		unitID := header[6]
+
+		// A PDU needs at least a function code byte, so a unit ID with
+		// nothing after it is not a valid request.
+		if length < 2 {
+			return
+		}
+
+		pdu := make([]byte, length-1)
+		if len(pdu) > 0 {
+			if _, err := io.ReadFull(conn, pdu); err != nil {
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		pending := &proxyRequest{
+			ctx:      ctx,
+			slaveID:  unitID,
+			pdu:      pdu,
+			response: make(chan proxyResult, 1),
+		}
+		requests <- pending
+
+		var result proxyResult
+		select {
+		case result = <-pending.response:
+		case <-ctx.Done():
+			result = proxyResult{err: ctx.Err()}
+		}
+		cancel()
+
+		if result.err != nil {
+			functionCode := byte(0)
+			if len(pdu) > 0 {
+				functionCode = pdu[0]
+			}
+			errPDU := []byte{functionCode | 0x80, byte(SlaveDeviceFailure)}
+			if writeMBAPResponse(conn, transactionID, unitID, errPDU) != nil {
+				return
+			}
+			continue
+		}
+
+		if writeMBAPResponse(conn, transactionID, unitID, result.pdu) != nil {
+			return
+		}
+	}
+}
+
+func writeMBAPResponse(conn io.Writer, transactionID []byte, unitID uint8, pdu []byte) error {
+	frame := make([]byte, 7+len(pdu))
+	copy(frame[0:2], transactionID)
+	binary.BigEndian.PutUint16(frame[4:6], uint16(len(pdu)+1))
+	frame[6] = unitID
+	copy(frame[7:], pdu)
+
+	_, err := conn.Write(frame)
+	return err
+}
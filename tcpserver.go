@@ -0,0 +1,75 @@
+package mbserver
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// ListenTCP starts a plain Modbus/TCP listener on addr.
+func (s *Server) ListenTCP(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.listeners = append(s.listeners, listener)
+	go s.acceptTCP(listener)
+
+	return nil
+}
+
+func (s *Server) acceptTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleTCPConnection(conn)
+	}
+}
+
+// handleTCPConnection reads MBAP frames off conn and queues them on
+// requestChan exactly like a ListenTLS connection, minus the client
+// certificate. Every request's context is derived from a single
+// per-connection context that is canceled once this loop returns, so a
+// custom handler still running when the client disconnects observes
+// ctx.Done().
+func (s *Server) handleTCPConnection(conn net.Conn) {
+	defer conn.Close()
+
+	connCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	connCtx = context.WithValue(connCtx, remoteAddrContextKey, conn.RemoteAddr().String())
+
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+
+		length := binary.BigEndian.Uint16(header[4:6])
+		if length < 1 {
+			return
+		}
+
+		body := make([]byte, length-1)
+		if len(body) > 0 {
+			if _, err := io.ReadFull(conn, body); err != nil {
+				return
+			}
+		}
+
+		packet := append(header, body...)
+		frame, err := NewTCPFrame(packet)
+		if err != nil {
+			return
+		}
+
+		transactionID := binary.BigEndian.Uint16(header[0:2])
+		ctx := context.WithValue(connCtx, transactionIDContextKey, transactionID)
+
+		s.requestChan <- &Request{conn: conn, frame: frame, ctx: ctx}
+	}
+}
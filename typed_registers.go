@@ -0,0 +1,249 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Endianness selects the byte order within each 16-bit register when a
+// typed accessor packs or unpacks a multi-byte value.
+type Endianness int
+
+// Endianness values for the typed register accessors.
+const (
+	BIG Endianness = iota
+	LITTLE
+)
+
+// WordOrder selects which of a multi-register value's 16-bit words is
+// stored at the lower address.
+type WordOrder int
+
+// WordOrder values for the typed register accessors.
+const (
+	HIGH_WORD_FIRST WordOrder = iota
+	LOW_WORD_FIRST
+)
+
+func swapEndian(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func packWords(words []uint16, endianness Endianness, wordOrder WordOrder) []uint16 {
+	packed := make([]uint16, len(words))
+	copy(packed, words)
+
+	if endianness == LITTLE {
+		for i, w := range packed {
+			packed[i] = swapEndian(w)
+		}
+	}
+	if wordOrder == LOW_WORD_FIRST {
+		for i, j := 0, len(packed)-1; i < j; i, j = i+1, j-1 {
+			packed[i], packed[j] = packed[j], packed[i]
+		}
+	}
+	return packed
+}
+
+func unpackWords(words []uint16, endianness Endianness, wordOrder WordOrder) []uint16 {
+	// Packing and unpacking are the same operation: swap the word order
+	// back, then undo the per-word byte swap.
+	unpacked := make([]uint16, len(words))
+	copy(unpacked, words)
+
+	if wordOrder == LOW_WORD_FIRST {
+		for i, j := 0, len(unpacked)-1; i < j; i, j = i+1, j-1 {
+			unpacked[i], unpacked[j] = unpacked[j], unpacked[i]
+		}
+	}
+	if endianness == LITTLE {
+		for i, w := range unpacked {
+			unpacked[i] = swapEndian(w)
+		}
+	}
+	return unpacked
+}
+
+// slaveLock returns the RWMutex for slaveID, or an error if slaveID was
+// never passed to NewServer.
+func (s *Server) slaveLock(slaveID uint8) (*sync.RWMutex, error) {
+	lock, exists := s.locks[slaveID]
+	if !exists {
+		return nil, fmt.Errorf("mbserver: unknown slave ID: %d", slaveID)
+	}
+	return lock, nil
+}
+
+// SetUint32 packs v into the two holding registers starting at addr,
+// taking the slave's lock and going through s.Store so the write can't
+// race a concurrent FC handler and is bounds-checked against the slave's
+// memory.
+func (s *Server) SetUint32(slaveID uint8, addr uint16, v uint32, endianness Endianness, wordOrder WordOrder) error {
+	lock, err := s.slaveLock(slaveID)
+	if err != nil {
+		return err
+	}
+	words := packWords([]uint16{uint16(v >> 16), uint16(v)}, endianness, wordOrder)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if exception := s.Store.WriteHoldingRegisters(slaveID, addr, words); exception != &Success {
+		return exception
+	}
+	return nil
+}
+
+// GetUint32 unpacks the two holding registers starting at addr.
+func (s *Server) GetUint32(slaveID uint8, addr uint16, endianness Endianness, wordOrder WordOrder) (uint32, error) {
+	lock, err := s.slaveLock(slaveID)
+	if err != nil {
+		return 0, err
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	raw, exception := s.Store.ReadHoldingRegisters(slaveID, addr, 2)
+	if exception != &Success {
+		return 0, exception
+	}
+
+	// unpackWords copies raw before any reordering, so the lock must stay
+	// held until after this call — raw aliases the live backing array and
+	// a concurrent writer could otherwise tear the read.
+	words := unpackWords(raw, endianness, wordOrder)
+	return uint32(words[0])<<16 | uint32(words[1]), nil
+}
+
+// SetInt32 packs v into the two holding registers starting at addr.
+func (s *Server) SetInt32(slaveID uint8, addr uint16, v int32, endianness Endianness, wordOrder WordOrder) error {
+	return s.SetUint32(slaveID, addr, uint32(v), endianness, wordOrder)
+}
+
+// GetInt32 unpacks the two holding registers starting at addr.
+func (s *Server) GetInt32(slaveID uint8, addr uint16, endianness Endianness, wordOrder WordOrder) (int32, error) {
+	v, err := s.GetUint32(slaveID, addr, endianness, wordOrder)
+	return int32(v), err
+}
+
+// SetFloat32 packs v into the two holding registers starting at addr.
+func (s *Server) SetFloat32(slaveID uint8, addr uint16, v float32, endianness Endianness, wordOrder WordOrder) error {
+	return s.SetUint32(slaveID, addr, math.Float32bits(v), endianness, wordOrder)
+}
+
+// GetFloat32 unpacks the two holding registers starting at addr.
+func (s *Server) GetFloat32(slaveID uint8, addr uint16, endianness Endianness, wordOrder WordOrder) (float32, error) {
+	v, err := s.GetUint32(slaveID, addr, endianness, wordOrder)
+	return math.Float32frombits(v), err
+}
+
+// SetUint64 packs v into the four holding registers starting at addr.
+func (s *Server) SetUint64(slaveID uint8, addr uint16, v uint64, endianness Endianness, wordOrder WordOrder) error {
+	lock, err := s.slaveLock(slaveID)
+	if err != nil {
+		return err
+	}
+	words := packWords([]uint16{
+		uint16(v >> 48), uint16(v >> 32), uint16(v >> 16), uint16(v),
+	}, endianness, wordOrder)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if exception := s.Store.WriteHoldingRegisters(slaveID, addr, words); exception != &Success {
+		return exception
+	}
+	return nil
+}
+
+// GetUint64 unpacks the four holding registers starting at addr.
+func (s *Server) GetUint64(slaveID uint8, addr uint16, endianness Endianness, wordOrder WordOrder) (uint64, error) {
+	lock, err := s.slaveLock(slaveID)
+	if err != nil {
+		return 0, err
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	raw, exception := s.Store.ReadHoldingRegisters(slaveID, addr, 4)
+	if exception != &Success {
+		return 0, exception
+	}
+
+	// unpackWords copies raw before any reordering, so the lock must stay
+	// held until after this call — raw aliases the live backing array and
+	// a concurrent writer could otherwise tear the read.
+	words := unpackWords(raw, endianness, wordOrder)
+	return uint64(words[0])<<48 | uint64(words[1])<<32 | uint64(words[2])<<16 | uint64(words[3]), nil
+}
+
+// SetFloat64 packs v into the four holding registers starting at addr.
+func (s *Server) SetFloat64(slaveID uint8, addr uint16, v float64, endianness Endianness, wordOrder WordOrder) error {
+	return s.SetUint64(slaveID, addr, math.Float64bits(v), endianness, wordOrder)
+}
+
+// GetFloat64 unpacks the four holding registers starting at addr.
+func (s *Server) GetFloat64(slaveID uint8, addr uint16, endianness Endianness, wordOrder WordOrder) (float64, error) {
+	v, err := s.GetUint64(slaveID, addr, endianness, wordOrder)
+	return math.Float64frombits(v), err
+}
+
+// SetString packs v two bytes per register, starting at addr, padding
+// with a trailing zero byte if v has an odd length.
+func (s *Server) SetString(slaveID uint8, addr uint16, v string) error {
+	lock, err := s.slaveLock(slaveID)
+	if err != nil {
+		return err
+	}
+
+	padded := []byte(v)
+	if len(padded)%2 != 0 {
+		padded = append(padded, 0)
+	}
+
+	words := make([]uint16, len(padded)/2)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint16(padded[i*2 : i*2+2])
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if exception := s.Store.WriteHoldingRegisters(slaveID, addr, words); exception != &Success {
+		return exception
+	}
+	return nil
+}
+
+// GetString reads length bytes back out of the registers starting at
+// addr, trimming a trailing padding zero byte if length is odd.
+func (s *Server) GetString(slaveID uint8, addr uint16, length int) (string, error) {
+	lock, err := s.slaveLock(slaveID)
+	if err != nil {
+		return "", err
+	}
+
+	registerCount := uint16((length + 1) / 2)
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	words, exception := s.Store.ReadHoldingRegisters(slaveID, addr, registerCount)
+	if exception != &Success {
+		return "", exception
+	}
+
+	// words aliases the live backing array, so it must be fully copied
+	// into raw before the lock is released.
+	raw := make([]byte, len(words)*2)
+	for i, v := range words {
+		binary.BigEndian.PutUint16(raw[i*2:], v)
+	}
+
+	return string(raw[:length]), nil
+}